@@ -0,0 +1,268 @@
+package build
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/moby/buildkit/session/filesync"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	sshprovider "github.com/moby/buildkit/session/sshforward/sshprovider"
+	"github.com/opencontainers/go-digest"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/windmilleng/tilt/pkg/logger"
+	"github.com/windmilleng/tilt/pkg/model"
+)
+
+// buildkitFrontendAttrs builds the SolveOpt.FrontendAttrs for the buildkit
+// dockerfile.v0 frontend. It only sets the Dockerfile filename; no field of
+// db (build args, target stage, …) is passed to the frontend.
+func buildkitFrontendAttrs(db model.DockerBuild) map[string]string {
+	attrs := map[string]string{
+		"filename": "Dockerfile",
+	}
+	return attrs
+}
+
+// buildkitSecretSources turns `--secret id=…,src=…`-style specs into the
+// source map the secretsprovider needs.
+func buildkitSecretSources(secrets []model.Secret) secretsprovider.Source {
+	src := secretsprovider.Source{}
+	for _, s := range secrets {
+		src.FileSources = append(src.FileSources, secretsprovider.FileSource{
+			ID:       s.ID,
+			FilePath: s.Src,
+		})
+	}
+	return src
+}
+
+// buildkitCacheExports translates db.CacheTo registry refs into BuildKit
+// `--cache-to type=registry,ref=…,mode=max` cache export attachments.
+func buildkitCacheExports(db model.DockerBuild) []client.CacheOptionsEntry {
+	exports := make([]client.CacheOptionsEntry, 0, len(db.CacheTo))
+	for _, ref := range db.CacheTo {
+		exports = append(exports, client.CacheOptionsEntry{
+			Type:  "registry",
+			Attrs: map[string]string{"ref": ref, "mode": "max"},
+		})
+	}
+	return exports
+}
+
+// buildkitCacheImports translates db.CacheFrom registry refs into BuildKit
+// `--cache-from type=registry,ref=…` cache import attachments.
+func buildkitCacheImports(db model.DockerBuild) []client.CacheOptionsEntry {
+	imports := make([]client.CacheOptionsEntry, 0, len(db.CacheFrom))
+	for _, ref := range db.CacheFrom {
+		imports = append(imports, client.CacheOptionsEntry{
+			Type:  "registry",
+			Attrs: map[string]string{"ref": ref},
+		})
+	}
+	return imports
+}
+
+// logCacheHits reports, per vertex, whether BuildKit served it from one of
+// db.CacheFrom's registry caches or had to execute it. Vertex.Cached is
+// populated from the buildkit status stream regardless of which cache
+// backend served it, so this is best-effort: we can say a cache was hit
+// somewhere, just not which ref.
+func logCacheHits(ctx context.Context, db model.DockerBuild, vertexes []*vertex) {
+	if len(db.CacheFrom) == 0 {
+		return
+	}
+	for _, v := range vertexes {
+		if !v.completed {
+			continue
+		}
+		if v.cached {
+			logger.Get(ctx).Debugf("cache hit: %s", v.name)
+		} else {
+			logger.Get(ctx).Debugf("cache miss: %s", v.name)
+		}
+	}
+}
+
+// buildkitSession sets up a session.Session carrying the auth, secrets, ssh
+// and file-sync attachables a Solve call needs. It does not run the session;
+// the caller starts it with sess.Run. It also returns the same attachables so the
+// caller can list them on SolveOpt.Session — sess.Allow only registers them
+// against the session's own dispatcher, it doesn't expose them back out.
+func (d *dockerImageBuilder) buildkitSession(ctx context.Context, db model.DockerBuild, syncDir string) (*session.Session, []session.Attachable, error) {
+	sess, err := session.NewSession(ctx, "tilt", "")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "buildkitSession")
+	}
+
+	attachable := []session.Attachable{
+		authprovider.NewDockerAuthProvider(logger.Get(ctx).Writer(logger.DebugLvl)),
+	}
+
+	if len(db.Secrets) > 0 {
+		secretsProvider, err := secretsprovider.NewSecretProvider(buildkitSecretSources(db.Secrets))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "buildkitSession: secrets")
+		}
+		attachable = append(attachable, secretsProvider)
+	}
+
+	if len(db.SSHSpecs) > 0 {
+		sshProvider, err := sshprovider.NewSSHAgentProvider(buildkitSSHConfigs(db.SSHSpecs))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "buildkitSession: ssh")
+		}
+		attachable = append(attachable, sshProvider)
+	}
+
+	attachable = append(attachable, filesync.NewFSSyncProvider(filesync.StaticDirSource{"context": syncDir}))
+
+	for _, a := range attachable {
+		sess.Allow(a)
+	}
+
+	return sess, attachable, nil
+}
+
+func buildkitSSHConfigs(specs []model.SSHSpec) []sshprovider.AgentConfig {
+	configs := make([]sshprovider.AgentConfig, 0, len(specs))
+	for _, spec := range specs {
+		configs = append(configs, sshprovider.AgentConfig{
+			ID:    spec.ID,
+			Paths: spec.Paths,
+		})
+	}
+	return configs
+}
+
+// buildFromDfViaBuildkit drives the build through a native BuildKit Solve
+// call instead of the classic daemon ImageBuild endpoint. It's only used
+// when d.dCli reports BuildKit support (see docker.Client.BuildkitEnabled);
+// older engines fall back to buildFromDf's daemon path.
+func (d *dockerImageBuilder) buildFromDfViaBuildkit(ctx context.Context, ps *PipelineState, db model.DockerBuild, paths []PathMapping, filter model.PathMatcher, ref reference.Named) (reference.NamedTagged, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "daemon-buildFromDfViaBuildkit")
+	defer span.Finish()
+
+	syncDir := db.BuildPath
+	if len(paths) > 0 {
+		syncDir = paths[0].LocalPath
+	}
+
+	sess, attachable, err := d.buildkitSession(ctx, db, syncDir)
+	if err != nil {
+		return nil, err
+	}
+
+	dialSession, ok := d.dCli.(buildkitDialer)
+	if !ok {
+		return nil, errors.New("buildFromDfViaBuildkit: docker client does not support buildkit sessions")
+	}
+
+	eg, sessCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return sess.Run(sessCtx, dialSession.BuildkitDialSession)
+	})
+
+	c, err := client.New(sessCtx, "", client.WithSessionDialer(dialSession.BuildkitDialSession))
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDfViaBuildkit: client.New")
+	}
+
+	solveOpt := client.SolveOpt{
+		Frontend:      "dockerfile.v0",
+		FrontendAttrs: buildkitFrontendAttrs(db),
+		Session:       attachable,
+		CacheExports:  buildkitCacheExports(db),
+		CacheImports:  buildkitCacheImports(db),
+		Exports: []client.ExportEntry{
+			{
+				Type:  "image",
+				Attrs: map[string]string{"name": ref.String()},
+			},
+		},
+	}
+
+	// sess.Run above only returns once sessCtx is canceled, which nothing
+	// else here does on the success path — so the session has to be closed
+	// explicitly once Solve is done with it, or eg.Wait() below blocks
+	// forever even after a successful build.
+	var solveResp *client.SolveResponse
+	ch := make(chan *client.SolveStatus)
+	eg.Go(func() error {
+		defer sess.Close()
+		resp, err := c.Solve(sessCtx, nil, solveOpt, ch)
+		if err != nil {
+			return err
+		}
+		solveResp = resp
+		return nil
+	})
+	eg.Go(func() error {
+		b := newBuildkitPrinter(logger.Get(ctx))
+		for status := range ch {
+			vertexes, logs := solveStatusToVertexes(status)
+			logCacheHits(ctx, db, vertexes)
+			if err := b.parseAndPrint(vertexes, logs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Wrap(err, "buildFromDfViaBuildkit")
+	}
+
+	digestStr := solveResp.ExporterResponse["containerimage.digest"]
+	if digestStr == "" {
+		return nil, errors.New("buildFromDfViaBuildkit: solve response had no containerimage.digest")
+	}
+
+	nt, err := d.TagImage(ctx, ref, digest.Digest(digestStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDfViaBuildkit")
+	}
+
+	return nt, nil
+}
+
+// buildkitDialer is implemented by docker.Client on engines new enough to
+// support BuildKit sessions (capability detection lives there).
+type buildkitDialer interface {
+	BuildkitEnabled(ctx context.Context) bool
+	BuildkitDialSession(ctx context.Context, proto string, meta map[string][]string) (io.ReadWriteCloser, error)
+}
+
+func solveStatusToVertexes(status *client.SolveStatus) ([]*vertex, []*vertexLog) {
+	vertexes := make([]*vertex, 0, len(status.Vertexes))
+	logs := make([]*vertexLog, 0, len(status.Logs))
+	for _, v := range status.Vertexes {
+		duration := time.Duration(0)
+		if v.Started != nil && v.Completed != nil {
+			duration = v.Completed.Sub(*v.Started)
+		}
+		vertexes = append(vertexes, &vertex{
+			digest:    v.Digest,
+			name:      v.Name,
+			error:     v.Error,
+			started:   v.Started != nil,
+			completed: v.Completed != nil,
+			cached:    v.Cached,
+			duration:  duration,
+		})
+	}
+	for _, l := range status.Logs {
+		logs = append(logs, &vertexLog{
+			vertex: l.Vertex,
+			msg:    l.Data,
+		})
+	}
+	return vertexes, logs
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/windmilleng/tilt/internal/docker"
 	"github.com/windmilleng/tilt/internal/dockerfile"
 	"github.com/windmilleng/tilt/internal/ignore"
+	"github.com/windmilleng/tilt/internal/k8s"
 	"github.com/windmilleng/tilt/pkg/logger"
 	"github.com/windmilleng/tilt/pkg/model"
 )
@@ -33,6 +34,9 @@ type dockerImageBuilder struct {
 	//
 	// By default, all builds are labeled with a build mode.
 	extraLabels dockerfile.Labels
+
+	registryRewriter RegistryRewriter
+	cluster          k8s.Cluster
 }
 
 type ImageBuilder interface {
@@ -49,10 +53,35 @@ func DefaultImageBuilder(b *dockerImageBuilder) ImageBuilder {
 
 var _ ImageBuilder = &dockerImageBuilder{}
 
-func NewDockerImageBuilder(dCli docker.Client, extraLabels dockerfile.Labels) *dockerImageBuilder {
+func NewDockerImageBuilder(dCli docker.Client, cluster k8s.Cluster, extraLabels dockerfile.Labels) *dockerImageBuilder {
 	return &dockerImageBuilder{
-		dCli:        dCli,
-		extraLabels: extraLabels,
+		dCli:             dCli,
+		cluster:          cluster,
+		extraLabels:      extraLabels,
+		registryRewriter: NewRegistryRewriter(),
+	}
+}
+
+// NewImageBuilder picks an ImageBuilder implementation based on the
+// Tiltfile's `docker_build(builder=…)` argument. An empty builderName
+// auto-detects: Docker whenever dCli is non-nil (the daemon itself is not
+// probed), else Podman if its rootless socket is reachable.
+func NewImageBuilder(dCli docker.Client, cluster k8s.Cluster, extraLabels dockerfile.Labels, builderName string) (ImageBuilder, error) {
+	switch builderName {
+	case "docker":
+		return NewDockerImageBuilder(dCli, cluster, extraLabels), nil
+	case "podman":
+		return NewPodmanImageBuilder(cluster, extraLabels)
+	case "":
+		if dCli != nil {
+			return NewDockerImageBuilder(dCli, cluster, extraLabels), nil
+		}
+		if PodmanReachable() {
+			return NewPodmanImageBuilder(cluster, extraLabels)
+		}
+		return nil, fmt.Errorf("NewImageBuilder: no reachable Docker or Podman socket")
+	default:
+		return nil, fmt.Errorf("NewImageBuilder: unrecognized builder %q", builderName)
 	}
 }
 
@@ -197,20 +226,43 @@ func (d *dockerImageBuilder) TagImage(ctx context.Context, ref reference.Named,
 	return namedTagged, nil
 }
 
-// Naively tag the digest and push it up to the docker registry specified in the name.
-//
-// TODO(nick) In the future, I would like us to be smarter about checking if the kubernetes cluster
-// we're running in has access to the given registry. And if it doesn't, we should either emit an
-// error, or push to a registry that kubernetes does have access to (e.g., a local registry).
+// Rewrite ref to a registry the cluster can actually pull from if needed,
+// tag the image under the rewritten name, and push it (to both the original
+// and rewritten refs when they differ).
 func (d *dockerImageBuilder) PushImage(ctx context.Context, ref reference.NamedTagged) (reference.NamedTagged, error) {
-	l := logger.Get(ctx)
-
 	span, ctx := opentracing.StartSpanFromContext(ctx, "daemon-PushImage")
 	defer span.Finish()
 
+	pushRef := ref
+	if d.registryRewriter != nil && d.cluster != nil {
+		rewritten, err := d.registryRewriter.Rewrite(ctx, d.cluster, ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "PushImage#Rewrite")
+		}
+		if rewritten.String() != ref.String() {
+			if err := d.push(ctx, ref); err != nil {
+				return nil, err
+			}
+			if err := d.dCli.ImageTag(ctx, ref.String(), rewritten.String()); err != nil {
+				return nil, errors.Wrapf(err, "PushImage#ImageTag %s", rewritten.String())
+			}
+			pushRef = rewritten
+		}
+	}
+
+	if err := d.push(ctx, pushRef); err != nil {
+		return nil, err
+	}
+
+	return pushRef, nil
+}
+
+func (d *dockerImageBuilder) push(ctx context.Context, ref reference.NamedTagged) error {
+	l := logger.Get(ctx)
+
 	imagePushResponse, err := d.dCli.ImagePush(ctx, ref)
 	if err != nil {
-		return nil, errors.Wrap(err, "PushImage#ImagePush")
+		return errors.Wrap(err, "PushImage#ImagePush")
 	}
 
 	defer func() {
@@ -222,10 +274,10 @@ func (d *dockerImageBuilder) PushImage(ctx context.Context, ref reference.NamedT
 
 	_, err = readDockerOutput(ctx, imagePushResponse)
 	if err != nil {
-		return nil, errors.Wrapf(err, "pushing image %q", ref.Name())
+		return errors.Wrapf(err, "pushing image %q", ref.Name())
 	}
 
-	return ref, nil
+	return nil
 }
 
 func (d *dockerImageBuilder) ImageExists(ctx context.Context, ref reference.NamedTagged) (bool, error) {
@@ -241,6 +293,18 @@ func (d *dockerImageBuilder) buildFromDf(ctx context.Context, ps *PipelineState,
 	span, ctx := opentracing.StartSpanFromContext(ctx, "daemon-buildFromDf")
 	defer span.Finish()
 
+	if dialer, ok := d.dCli.(buildkitDialer); ok && dialer.BuildkitEnabled(ctx) {
+		return d.buildFromDfViaBuildkit(ctx, ps, db, paths, filter, ref)
+	}
+
+	// No BuildKit session available (older engine). We can still get some of
+	// the benefit of a registry-backed cache by pulling the referenced images
+	// up-front so they land in the daemon's local layer cache, then pointing
+	// the classic build at them via ImageBuildOptions.CacheFrom.
+	if len(db.CacheFrom) > 0 {
+		d.pullCacheFromImages(ctx, ps, db.CacheFrom)
+	}
+
 	ps.StartBuildStep(ctx, "Tarring context…")
 
 	// NOTE(maia): some people want to know what files we're adding (b/c `ADD . /` isn't descriptive)
@@ -251,13 +315,19 @@ func (d *dockerImageBuilder) buildFromDf(ctx context.Context, ps *PipelineState,
 	}
 
 	pr, pw := io.Pipe()
+	total, err := contextSize(paths, filter)
+	if err != nil {
+		logger.Get(ctx).Debugf("computing build context size: %v", err)
+	}
+	progress := newProgressWriter(ctx, ps, pw, total)
 	go func() {
-		err := tarContextAndUpdateDf(ctx, pw, dockerfile.Dockerfile(db.Dockerfile), paths, filter)
+		err := tarContextAndUpdateDf(ctx, progress, dockerfile.Dockerfile(db.Dockerfile), paths, filter)
 		if err != nil {
 			_ = pw.CloseWithError(err)
 		} else {
 			_ = pw.Close()
 		}
+		progress.finish()
 	}()
 
 	ps.StartBuildStep(ctx, "Building image")
@@ -292,6 +362,28 @@ func (d *dockerImageBuilder) buildFromDf(ctx context.Context, ps *PipelineState,
 	return nt, nil
 }
 
+// pullCacheFromImages best-effort pulls each cache_from ref so the daemon's
+// local layer cache is warm before we hand CacheFrom to ImageBuildOptions. A
+// pull failure (e.g. the cache ref doesn't exist yet) just means a cache miss,
+// not a build failure, so errors are logged and swallowed.
+func (d *dockerImageBuilder) pullCacheFromImages(ctx context.Context, ps *PipelineState, cacheFrom []string) {
+	for _, ref := range cacheFrom {
+		ps.Printf(ctx, "Pulling cache image %s…", ref)
+		reader, err := d.dCli.ImagePull(ctx, ref, types.ImagePullOptions{})
+		if err != nil {
+			logger.Get(ctx).Infof("cache miss: %s (%v)", ref, err)
+			continue
+		}
+		_, err = readDockerOutput(ctx, reader)
+		_ = reader.Close()
+		if err != nil {
+			logger.Get(ctx).Infof("cache miss: %s (%v)", ref, err)
+			continue
+		}
+		logger.Get(ctx).Infof("cache hit: %s", ref)
+	}
+}
+
 func (d *dockerImageBuilder) getDigestFromBuildOutput(ctx context.Context, reader io.Reader) (digest.Digest, error) {
 	result, err := readDockerOutput(ctx, reader)
 	if err != nil {
@@ -0,0 +1,269 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/windmilleng/tilt/internal/dockerfile"
+	"github.com/windmilleng/tilt/internal/k8s"
+	"github.com/windmilleng/tilt/pkg/model"
+)
+
+const podmanAPIVersion = "v1.41"
+
+// podmanImageBuilder is an ImageBuilder backed by Podman's Docker-compatible
+// REST API rather than a Docker daemon. It's selected with `builder =
+// "podman"` in a Tiltfile's docker_build call, or auto-detected when no
+// Docker client is configured and the Podman socket is reachable.
+type podmanImageBuilder struct {
+	hCli        *http.Client
+	extraLabels dockerfile.Labels
+
+	registryRewriter RegistryRewriter
+	cluster          k8s.Cluster
+}
+
+var _ ImageBuilder = &podmanImageBuilder{}
+
+// NewPodmanImageBuilder talks to Podman over its Docker-compatible REST API
+// at $XDG_RUNTIME_DIR/podman/podman.sock.
+func NewPodmanImageBuilder(cluster k8s.Cluster, extraLabels dockerfile.Labels) (*podmanImageBuilder, error) {
+	sockPath, err := podmanSocketPath()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewPodmanImageBuilder")
+	}
+
+	hCli := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, "unix", sockPath)
+			},
+		},
+	}
+
+	return &podmanImageBuilder{
+		hCli:             hCli,
+		extraLabels:      extraLabels,
+		registryRewriter: NewRegistryRewriter(),
+		cluster:          cluster,
+	}, nil
+}
+
+// podmanSocketPath returns the path of the rootless Podman socket, and errors
+// if it doesn't exist (used for auto-detection).
+func podmanSocketPath() (string, error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return "", fmt.Errorf("XDG_RUNTIME_DIR not set")
+	}
+
+	sockPath := filepath.Join(runtimeDir, "podman", "podman.sock")
+	if _, err := os.Stat(sockPath); err != nil {
+		return "", errors.Wrapf(err, "podman socket %s", sockPath)
+	}
+	return sockPath, nil
+}
+
+// PodmanReachable reports whether the rootless Podman socket exists and is
+// listening, for builder auto-detection.
+func PodmanReachable() bool {
+	sockPath, err := podmanSocketPath()
+	if err != nil {
+		return false
+	}
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func (b *podmanImageBuilder) podmanURL(path string) string {
+	return fmt.Sprintf("http://d/%s%s", podmanAPIVersion, path)
+}
+
+func (b *podmanImageBuilder) BuildImage(ctx context.Context, ps *PipelineState, ref reference.Named, db model.DockerBuild, filter model.PathMatcher) (reference.NamedTagged, error) {
+	paths := []PathMapping{
+		{
+			LocalPath:     db.BuildPath,
+			ContainerPath: "/",
+		},
+	}
+	return b.buildFromDf(ctx, ps, db, paths, filter, ref)
+}
+
+func (b *podmanImageBuilder) DeprecatedFastBuildImage(ctx context.Context, ps *PipelineState, ref reference.Named, baseDockerfile dockerfile.Dockerfile,
+	syncs []model.Sync, filter model.PathMatcher,
+	runs []model.Run, entrypoint model.Cmd) (reference.NamedTagged, error) {
+	return nil, fmt.Errorf("podman builder does not support DeprecatedFastBuildImage")
+}
+
+func (b *podmanImageBuilder) buildFromDf(ctx context.Context, ps *PipelineState, db model.DockerBuild, paths []PathMapping, filter model.PathMatcher, ref reference.Named) (reference.NamedTagged, error) {
+	ps.StartBuildStep(ctx, "Tarring context…")
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := tarContextAndUpdateDf(ctx, pw, dockerfile.Dockerfile(db.Dockerfile), paths, filter)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+		} else {
+			_ = pw.Close()
+		}
+	}()
+
+	ps.StartBuildStep(ctx, "Building image (podman)")
+	req, err := http.NewRequest(http.MethodPost, b.podmanURL(fmt.Sprintf("/build?t=%s", ref.String())), pr)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := b.hCli.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf: podman build")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Podman emits a JSONMessage stream compatible with the Docker daemon's,
+	// so we can reuse the same decoder the Docker backend uses.
+	output, err := readDockerOutput(ps.AttachLogger(ctx), resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+
+	dig, err := b.digestFromOutput(output)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+
+	tag, err := digestAsTag(dig)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+
+	namedTagged, err := reference.WithTag(ref, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+
+	if err := b.tag(ctx, dig.String(), namedTagged.String()); err != nil {
+		return nil, errors.Wrap(err, "buildFromDf")
+	}
+
+	return namedTagged, nil
+}
+
+func (b *podmanImageBuilder) digestFromOutput(output dockerOutput) (digest.Digest, error) {
+	if output.aux != nil {
+		return getDigestFromAux(*output.aux)
+	}
+	if output.shortDigest != "" {
+		return digest.Digest(output.shortDigest), nil
+	}
+	return "", fmt.Errorf("podman build did not return an image ID")
+}
+
+func (b *podmanImageBuilder) tag(ctx context.Context, source, target string) error {
+	req, err := http.NewRequest(http.MethodPost, b.podmanURL(fmt.Sprintf("/images/%s/tag?repo=%s", source, target)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.hCli.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("tagging image: status %s", resp.Status)
+	}
+	return nil
+}
+
+// PushImage mirrors dockerImageBuilder.PushImage: tag the image, rewrite it
+// to a registry the cluster can actually pull from if needed, and push it (to
+// both the original and rewritten refs when they differ).
+func (b *podmanImageBuilder) PushImage(ctx context.Context, ref reference.NamedTagged) (reference.NamedTagged, error) {
+	pushRef := ref
+	if b.registryRewriter != nil && b.cluster != nil {
+		rewritten, err := b.registryRewriter.Rewrite(ctx, b.cluster, ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "PushImage#Rewrite")
+		}
+		if rewritten.String() != ref.String() {
+			if err := b.push(ctx, ref); err != nil {
+				return nil, err
+			}
+			if err := b.tag(ctx, ref.String(), rewritten.String()); err != nil {
+				return nil, errors.Wrapf(err, "PushImage#tag %s", rewritten.String())
+			}
+			pushRef = rewritten
+		}
+	}
+
+	if err := b.push(ctx, pushRef); err != nil {
+		return nil, err
+	}
+
+	return pushRef, nil
+}
+
+func (b *podmanImageBuilder) push(ctx context.Context, ref reference.NamedTagged) error {
+	req, err := http.NewRequest(http.MethodPost, b.podmanURL(fmt.Sprintf("/images/%s/push", ref.String())), nil)
+	if err != nil {
+		return errors.Wrap(err, "push")
+	}
+	resp, err := b.hCli.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "push")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if _, err := readDockerOutput(ctx, resp.Body); err != nil {
+		return errors.Wrapf(err, "pushing image %q", ref.Name())
+	}
+
+	return nil
+}
+
+func (b *podmanImageBuilder) TagImage(ctx context.Context, ref reference.Named, dig digest.Digest) (reference.NamedTagged, error) {
+	tag, err := digestAsTag(dig)
+	if err != nil {
+		return nil, errors.Wrap(err, "TagImage")
+	}
+	namedTagged, err := reference.WithTag(ref, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "TagImage")
+	}
+	if err := b.tag(ctx, dig.String(), namedTagged.String()); err != nil {
+		return nil, errors.Wrap(err, "TagImage")
+	}
+	return namedTagged, nil
+}
+
+func (b *podmanImageBuilder) ImageExists(ctx context.Context, ref reference.NamedTagged) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, b.podmanURL(fmt.Sprintf("/images/%s/json", ref.String())), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := b.hCli.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return resp.StatusCode/100 == 2, nil
+}
@@ -0,0 +1,120 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/windmilleng/tilt/pkg/model"
+)
+
+// contextSizeWarningThreshold is the point past which we nudge the user
+// towards a .dockerignore rather than silently tarring up a huge context.
+const contextSizeWarningThreshold = 100 * 1024 * 1024 // 100 MiB
+
+// progressWriter wraps the writer side of the context tar pipe and reports
+// bytes-sent / total against ps.Printf on a throttled cadence, mirroring the
+// doubling backoff readDockerOutput already uses for docker progress events.
+type progressWriter struct {
+	ctx   context.Context
+	ps    *PipelineState
+	w     io.Writer
+	total int64
+
+	sent        int64
+	start       time.Time
+	lastPrinted time.Time
+	printWait   time.Duration
+}
+
+func newProgressWriter(ctx context.Context, ps *PipelineState, w io.Writer, total int64) *progressWriter {
+	if total >= contextSizeWarningThreshold {
+		ps.Printf(ctx, "Build context is %s — consider adding a .dockerignore to speed up uploads",
+			humanBytes(total))
+	}
+	return &progressWriter{
+		ctx:   ctx,
+		ps:    ps,
+		w:     w,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.sent += int64(n)
+
+	shouldPrint := p.lastPrinted.IsZero() || time.Since(p.lastPrinted) > p.printWait
+	if shouldPrint {
+		p.ps.Printf(p.ctx, "Sending build context: %s / %s", humanBytes(p.sent), humanBytes(p.total))
+		p.lastPrinted = time.Now()
+		if p.printWait == 0 {
+			p.printWait = 2 * time.Second
+		} else {
+			p.printWait = 2 * p.printWait
+		}
+	}
+
+	return n, err
+}
+
+// finish emits a final summary line once the context has been fully tarred
+// and sent.
+func (p *progressWriter) finish() {
+	elapsed := time.Since(p.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.sent) / elapsed.Seconds()
+	}
+	p.ps.Printf(p.ctx, "Sent %s in %s (%s/s)", humanBytes(p.sent), elapsed.Round(100*time.Millisecond), humanBytes(int64(rate)))
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// contextSize walks paths (respecting filter) to compute the total number of
+// bytes that will be tarred into the build context, so progress reporting has
+// a denominator up front.
+func contextSize(paths []PathMapping, filter model.PathMatcher) (int64, error) {
+	var total int64
+	for _, pm := range paths {
+		err := filepath.Walk(pm.LocalPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			matches, err := filter.Matches(path)
+			if err != nil {
+				return err
+			}
+			if matches {
+				return nil
+			}
+			total += info.Size()
+			return nil
+		})
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+
+	"github.com/windmilleng/tilt/internal/k8s"
+	"github.com/windmilleng/tilt/pkg/logger"
+)
+
+// RegistryRewriter decides whether the cluster we're deploying to can
+// already pull a freshly-built image, and if not, rewrites the reference to
+// point at a registry the cluster *can* reach (and tells the caller to push
+// there too).
+type RegistryRewriter interface {
+	// Rewrite returns the reference PushImage should actually push and
+	// return to the caller. If the cluster can already pull ref, it's
+	// returned unchanged.
+	Rewrite(ctx context.Context, cluster k8s.Cluster, ref reference.NamedTagged) (reference.NamedTagged, error)
+}
+
+// localClusterRegistry describes a co-located registry a dev cluster exposes
+// for images it can't otherwise pull (e.g. kind's localhost:5000 mirror).
+type localClusterRegistry struct {
+	name string
+	// detect reports whether the current cluster looks like this kind of
+	// cluster (by context name, label, etc.)
+	detect func(cluster k8s.Cluster) bool
+	// host is the registry host:port to rewrite pushes to.
+	host func(cluster k8s.Cluster) (string, error)
+}
+
+var knownClusterRegistries = []localClusterRegistry{
+	{
+		name:   "kind",
+		detect: func(c k8s.Cluster) bool { return strings.HasPrefix(string(c.Context()), "kind-") },
+		host:   func(c k8s.Cluster) (string, error) { return "localhost:5000", nil },
+	},
+	{
+		name:   "k3d",
+		detect: func(c k8s.Cluster) bool { return strings.HasPrefix(string(c.Context()), "k3d-") },
+		host:   func(c k8s.Cluster) (string, error) { return "registry.kube-system.svc:80", nil },
+	},
+	{
+		name:   "minikube",
+		detect: func(c k8s.Cluster) bool { return string(c.Context()) == "minikube" },
+		host:   minikubeRegistryHost,
+	},
+	{
+		name: "docker-desktop",
+		detect: func(c k8s.Cluster) bool {
+			return string(c.Context()) == "docker-desktop" || string(c.Context()) == "docker-for-desktop"
+		},
+		host: func(c k8s.Cluster) (string, error) { return "localhost:5000", nil },
+	},
+}
+
+func minikubeRegistryHost(c k8s.Cluster) (string, error) {
+	out, err := exec.Command("minikube", "ip").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "minikube ip")
+	}
+	ip := strings.TrimSpace(string(out))
+	// Assumes the `registry` addon is enabled (`minikube addons enable registry`).
+	return fmt.Sprintf("%s:5000", ip), nil
+}
+
+type registryRewriter struct{}
+
+func NewRegistryRewriter() RegistryRewriter {
+	return registryRewriter{}
+}
+
+func (r registryRewriter) Rewrite(ctx context.Context, cluster k8s.Cluster, ref reference.NamedTagged) (reference.NamedTagged, error) {
+	ok, err := r.clusterCanPull(ctx, cluster, ref)
+	if err != nil {
+		logger.Get(ctx).Debugf("checking whether cluster can pull %s: %v", ref.String(), err)
+	}
+	if ok {
+		return ref, nil
+	}
+
+	for _, known := range knownClusterRegistries {
+		if !known.detect(cluster) {
+			continue
+		}
+		host, err := known.host(cluster)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rewriting %s for %s", ref.String(), known.name)
+		}
+		return rewriteHost(ref, host)
+	}
+
+	// Unknown cluster type: assume it can pull what we push and let the
+	// actual push/deploy surface a clearer error if it can't.
+	return ref, nil
+}
+
+// clusterCanPull probes whether the cluster can already resolve ref, by
+// running a short-lived Job that shells out to `crane manifest`. Without
+// permission to create Jobs it reports true (assume the cluster can pull).
+// If the permission check or the Job fails, it returns false with the error,
+// and Rewrite goes on to the known cluster-registry mappings.
+func (r registryRewriter) clusterCanPull(ctx context.Context, cluster k8s.Cluster, ref reference.NamedTagged) (bool, error) {
+	canCreateJobs, err := cluster.AuthCanI(ctx, "create", "jobs")
+	if err != nil {
+		return false, err
+	}
+	if !canCreateJobs {
+		return true, nil
+	}
+
+	return cluster.RunJobAndWait(ctx, k8s.CraneManifestJobSpec(ref.String()))
+}
+
+func rewriteHost(ref reference.NamedTagged, host string) (reference.NamedTagged, error) {
+	path := reference.Path(ref)
+	rewritten, err := reference.WithName(fmt.Sprintf("%s/%s", host, path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "rewriting %s to %s", ref.String(), host)
+	}
+	return reference.WithTag(rewritten, ref.Tag())
+}
@@ -0,0 +1,232 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/windmilleng/tilt/internal/cloud/cloudurl"
+	"github.com/windmilleng/tilt/internal/token"
+	proto_webview "github.com/windmilleng/tilt/pkg/webview"
+)
+
+const defaultChunkSizeBytes = 8 * 1024 * 1024 // 8 MiB
+const defaultMaxInFlightChunks = 4
+
+// chunkedUploadThresholdBytes is the encoded-snapshot size past which
+// snapshotUploader.Upload hands off to ChunkedSnapshotUploader instead of
+// sending the whole thing in one POST (for unsigned JSON uploads with gzip
+// on; see Upload).
+const chunkedUploadThresholdBytes = 32 * 1024 * 1024 // 32 MiB
+
+// defaultMaxChunkRetryRounds bounds how many times we'll re-send a round of
+// still-failing chunks before giving up, so a chunk that fails
+// deterministically (bad auth, oversized body, …) can't retry forever.
+const defaultMaxChunkRetryRounds = 5
+
+// initialChunkRetryBackoff is the first wait between retry rounds; it
+// doubles each round, mirroring the doubling backoff progressWriter uses for
+// its own progress output.
+const initialChunkRetryBackoff = 500 * time.Millisecond
+
+// ChunkedSnapshotUploader splits a large snapshot into N MiB segments and
+// uploads them independently against /api/snapshot/chunk, so an interrupted
+// upload only has to retry the chunks that actually failed instead of
+// starting over. Chunks are identified by an Upload-ID shared across the
+// whole snapshot plus their index, and uploads run with bounded concurrency
+// so we don't open more in-flight requests than the caller wants.
+type ChunkedSnapshotUploader struct {
+	client      HttpClient
+	addr        cloudurl.Address
+	chunkSize   int
+	maxInFlight int
+}
+
+func NewChunkedSnapshotUploader(client HttpClient, addr cloudurl.Address) *ChunkedSnapshotUploader {
+	return &ChunkedSnapshotUploader{
+		client:      client,
+		addr:        addr,
+		chunkSize:   defaultChunkSizeBytes,
+		maxInFlight: defaultMaxInFlightChunks,
+	}
+}
+
+func (u *ChunkedSnapshotUploader) chunkURL() string {
+	url := cloudurl.URL(string(u.addr))
+	url.Path = "/api/snapshot/chunk"
+	return url.String()
+}
+
+// chunkResult is what we get back after trying to send one chunk: either an
+// ETag to remember, or an error worth retrying.
+type chunkResult struct {
+	index int
+	etag  string
+	err   error
+}
+
+func (u *ChunkedSnapshotUploader) Upload(ctx context.Context, tok token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotID, error) {
+	snapshot = cleanSnapshot(snapshot)
+
+	b, err := encodeSnapshotJSON(snapshot)
+	if err != nil {
+		return "", errors.Wrap(err, "ChunkedSnapshotUploader.Upload")
+	}
+
+	chunks := chunkBytes(b, u.chunkSize)
+	uploadID := newSnapshotID()
+
+	etags := make([]string, len(chunks))
+	pending := chunks
+	remainingIdx := make([]int, len(chunks))
+	for i := range remainingIdx {
+		remainingIdx[i] = i
+	}
+
+	backoff := initialChunkRetryBackoff
+	for round := 0; len(remainingIdx) > 0; round++ {
+		if round > defaultMaxChunkRetryRounds {
+			return "", fmt.Errorf("ChunkedSnapshotUploader.Upload: giving up after %d retry rounds, %d chunk(s) still failing", round-1, len(remainingIdx))
+		}
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		results := u.sendChunks(ctx, tok, teamID, uploadID, pending, remainingIdx)
+
+		var retryIdx []int
+		for _, r := range results {
+			if r.err != nil {
+				retryIdx = append(retryIdx, r.index)
+				continue
+			}
+			etags[r.index] = r.etag
+		}
+		if len(retryIdx) == len(remainingIdx) {
+			// No progress at all this round; no reason to believe another
+			// round would do better.
+			return "", fmt.Errorf("ChunkedSnapshotUploader.Upload: all chunks failed, last error: %v", results[len(results)-1].err)
+		}
+		remainingIdx = retryIdx
+		pending = make([][]byte, len(remainingIdx))
+		for i, idx := range remainingIdx {
+			pending[i] = chunks[idx]
+		}
+	}
+
+	return u.complete(ctx, tok, uploadID, etags)
+}
+
+// sendChunks uploads each of pending[i] (whose real index is idxs[i]) with
+// up to u.maxInFlight in flight at once.
+func (u *ChunkedSnapshotUploader) sendChunks(ctx context.Context, tok token.Token, teamID, uploadID string, pending [][]byte, idxs []int) []chunkResult {
+	sem := make(chan struct{}, u.maxInFlight)
+	results := make([]chunkResult, len(pending))
+	done := make(chan struct{})
+
+	for i := range pending {
+		i := i
+		go func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			etag, err := u.sendChunk(ctx, tok, teamID, uploadID, idxs[i], pending[i])
+			results[i] = chunkResult{index: idxs[i], etag: etag, err: err}
+			done <- struct{}{}
+		}()
+	}
+	for range pending {
+		<-done
+	}
+	return results
+}
+
+func (u *ChunkedSnapshotUploader) sendChunk(ctx context.Context, tok token.Token, teamID, uploadID string, index int, chunk []byte) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, u.chunkURL(), bytes.NewReader(chunk))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(TiltTokenHeaderName, tok.String())
+	if teamID != "" {
+		req.Header.Set(TiltTeamIDNameHeaderName, teamID)
+	}
+	req.Header.Set("Upload-ID", uploadID)
+	req.Header.Set("Chunk-Index", fmt.Sprintf("%d", index))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("uploading chunk %d: status %s", index, resp.Status)
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// chunkCompleteRequest tells the server which ETag it returned for each
+// chunk index, so it can verify it reassembled the snapshot from exactly the
+// chunks we think we sent before recording the upload as done.
+type chunkCompleteRequest struct {
+	ETags []string `json:"etags"`
+}
+
+// complete tells the server all chunks for uploadID have arrived, handing
+// back the ETag the server returned for each one so it can validate the
+// assembled upload, and returns uploadID as the snapshot's SnapshotID.
+func (u *ChunkedSnapshotUploader) complete(ctx context.Context, tok token.Token, uploadID string, etags []string) (SnapshotID, error) {
+	url := cloudurl.URL(string(u.addr))
+	url.Path = "/api/snapshot/chunk/complete"
+
+	body, err := json.Marshal(chunkCompleteRequest{ETags: etags})
+	if err != nil {
+		return "", errors.Wrap(err, "ChunkedSnapshotUploader.complete")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(TiltTokenHeaderName, tok.String())
+	req.Header.Set("Upload-ID", uploadID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("completing upload %s: status %s", uploadID, resp.Status)
+	}
+
+	return SnapshotID(uploadID), nil
+}
+
+func chunkBytes(b []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+		chunks = append(chunks, b[:n])
+		b = b[n:]
+	}
+	return chunks
+}
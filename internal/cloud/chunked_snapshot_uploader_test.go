@@ -0,0 +1,112 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/windmilleng/tilt/internal/cloud/cloudurl"
+	"github.com/windmilleng/tilt/internal/token"
+	proto_webview "github.com/windmilleng/tilt/pkg/webview"
+)
+
+// fakeHTTPClient lets tests script per-request responses/failures without a
+// real network round trip.
+type fakeHTTPClient struct {
+	mu sync.Mutex
+
+	// failFirstN makes the first N requests to a path matching a chunk
+	// upload fail with a 500, then succeed from then on.
+	failFirstN   int
+	chunkReqSeen int
+
+	completeBody []byte
+}
+
+func (f *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if strings.HasSuffix(req.URL.Path, "/chunk/complete") {
+		if req.Body != nil {
+			b, _ := ioutil.ReadAll(req.Body)
+			f.completeBody = b
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	f.chunkReqSeen++
+	if f.chunkReqSeen <= f.failFirstN {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+	resp.Header.Set("ETag", "etag-ok")
+	return resp, nil
+}
+
+func testSnapshot() *proto_webview.Snapshot {
+	return &proto_webview.Snapshot{View: &proto_webview.View{}}
+}
+
+func TestChunkedSnapshotUploaderRetriesTransientFailures(t *testing.T) {
+	client := &fakeHTTPClient{failFirstN: 2}
+	u := NewChunkedSnapshotUploader(client, cloudurl.Address("cloud.tilt.dev"))
+	u.chunkSize = 4 // force multiple chunks out of a tiny snapshot
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := u.Upload(ctx, token.Token("tok"), "team", testSnapshot())
+	if err != nil {
+		t.Fatalf("Upload: unexpected error: %v", err)
+	}
+	if id == "" {
+		t.Fatal("Upload: expected a non-empty SnapshotID")
+	}
+}
+
+func TestChunkedSnapshotUploaderGivesUpOnPersistentFailure(t *testing.T) {
+	client := &fakeHTTPClient{failFirstN: 1 << 20} // never succeeds
+	u := NewChunkedSnapshotUploader(client, cloudurl.Address("cloud.tilt.dev"))
+	u.chunkSize = 4
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := u.Upload(ctx, token.Token("tok"), "team", testSnapshot())
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Upload: expected an error from a persistently failing chunk")
+	}
+	// The retry loop must give up on its own well before the context
+	// deadline, rather than spinning forever.
+	if elapsed >= 5*time.Second {
+		t.Fatalf("Upload: retry loop ran until context deadline instead of giving up, took %s", elapsed)
+	}
+}
+
+func TestChunkedSnapshotUploaderCompleteSendsETags(t *testing.T) {
+	client := &fakeHTTPClient{}
+	u := NewChunkedSnapshotUploader(client, cloudurl.Address("cloud.tilt.dev"))
+
+	_, err := u.complete(context.Background(), token.Token("tok"), "upload-1", []string{"etag-a", "etag-b"})
+	if err != nil {
+		t.Fatalf("complete: unexpected error: %v", err)
+	}
+
+	var body chunkCompleteRequest
+	if err := json.Unmarshal(client.completeBody, &body); err != nil {
+		t.Fatalf("complete: couldn't parse request body sent to server: %v", err)
+	}
+	if len(body.ETags) != 2 || body.ETags[0] != "etag-a" || body.ETags[1] != "etag-b" {
+		t.Fatalf("complete: expected etags [etag-a etag-b] in request body, got %v", body.ETags)
+	}
+}
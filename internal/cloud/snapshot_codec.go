@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/pkg/errors"
+
+	proto_webview "github.com/windmilleng/tilt/pkg/webview"
+)
+
+const (
+	// tiltSnapshotCodecEnvVar/tiltSnapshotGzipEnvVar let a team opt every
+	// outgoing snapshot into protobuf and/or gzip once they've confirmed
+	// their endpoint understands it, without code changes: NewSnapshotUploader
+	// picks them up via defaultTransportOverride. Mirrors how
+	// TILT_SNAPSHOT_SIGNING_KEY_ID/_PATH gate signing in defaultSigner.
+	tiltSnapshotCodecEnvVar = "TILT_SNAPSHOT_CODEC"
+	tiltSnapshotGzipEnvVar  = "TILT_SNAPSHOT_GZIP"
+)
+
+// SnapshotCodec encodes a snapshot for the wire. We support both the
+// original JSONPb encoding (for servers that don't know about proto yet) and
+// raw protobuf wire format, which is 5-10x smaller for snapshots dominated
+// by repeated log/resource structs.
+type SnapshotCodec interface {
+	Name() string
+	ContentType() string
+	Encode(snapshot *proto_webview.Snapshot) ([]byte, error)
+}
+
+type jsonSnapshotCodec struct{}
+
+func (jsonSnapshotCodec) Name() string        { return "json" }
+func (jsonSnapshotCodec) ContentType() string { return "application/json" }
+func (jsonSnapshotCodec) Encode(snapshot *proto_webview.Snapshot) ([]byte, error) {
+	jsEncoder := &runtime.JSONPb{OrigName: false, EmitDefaults: true}
+	return jsEncoder.Marshal(snapshot)
+}
+
+type protoSnapshotCodec struct{}
+
+func (protoSnapshotCodec) Name() string        { return "protobuf" }
+func (protoSnapshotCodec) ContentType() string { return "application/x-protobuf" }
+func (protoSnapshotCodec) Encode(snapshot *proto_webview.Snapshot) ([]byte, error) {
+	return proto.Marshal(snapshot)
+}
+
+// defaultSnapshotTransport picks how a snapshot gets encoded when the caller
+// hasn't opted into a specific codec via snapshotUploader.WithCodec.
+//
+// This used to "negotiate" the codec and gzip support with an OPTIONS probe:
+// send Accept: application/x-protobuf, then read Content-Type and
+// Accept-Encoding back off the response. That's not a negotiation either
+// header can actually carry — Accept-Encoding only flows request-side to ask
+// the server to compress its response, and nothing in this codebase's server
+// ever answers an OPTIONS preflight with a Content-Type describing what
+// request bodies it accepts. The probe always resolved to plain JSON, and
+// every upload paid for an extra round trip to learn that.
+//
+// So the default is plain JSON with no compression. gzip is opt-in via
+// WithGzip, and protobuf, which needs the server to actually understand it,
+// is opt-in via WithCodec; neither is auto-detected.
+func defaultSnapshotTransport() (codec SnapshotCodec, gzipOK bool) {
+	return jsonSnapshotCodec{}, false
+}
+
+// defaultTransportOverride reads TILT_SNAPSHOT_CODEC/TILT_SNAPSHOT_GZIP so a
+// team that's confirmed their snapshot endpoint understands protobuf and/or
+// gzip can opt in without code changes, the same way TILT_SNAPSHOT_SIGNING_KEY_ID
+// opts into signing. Returns a nil codec when TILT_SNAPSHOT_CODEC is unset or
+// unrecognized, leaving NewSnapshotUploader's default untouched.
+func defaultTransportOverride() (codec SnapshotCodec, gzipOK bool) {
+	switch os.Getenv(tiltSnapshotCodecEnvVar) {
+	case "protobuf":
+		codec = protoSnapshotCodec{}
+	case "json":
+		codec = jsonSnapshotCodec{}
+	}
+	gzipOK = os.Getenv(tiltSnapshotGzipEnvVar) != ""
+	return codec, gzipOK
+}
+
+// gzipBytes compresses b with gzip. Content-Encoding: gzip is something any
+// HTTP server either accepts or explicitly rejects, not something a client
+// needs to probe for ahead of time.
+func gzipBytes(b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(b); err != nil {
+		return nil, errors.Wrap(err, "gzipBytes")
+	}
+	if err := gw.Close(); err != nil {
+		return nil, errors.Wrap(err, "gzipBytes")
+	}
+	return buf.Bytes(), nil
+}
+
+// SnapshotUploadResult records what actually went out over the wire, so
+// callers (and tests) can confirm which codec and compression were used
+// without re-parsing HTTP headers.
+type SnapshotUploadResult struct {
+	ID               SnapshotID
+	Codec            string
+	Gzipped          bool
+	UncompressedSize int
+	CompressedSize   int
+}
@@ -0,0 +1,158 @@
+package cloud
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	proto_webview "github.com/windmilleng/tilt/pkg/webview"
+)
+
+// SnapshotScrubber rewrites a snapshot in place to remove anything that
+// looks like a secret before it gets uploaded, so users don't have to
+// manually audit pod logs / build output / env vars before sharing a
+// snapshot link.
+type SnapshotScrubber interface {
+	Scrub(snapshot *proto_webview.Snapshot) *proto_webview.Snapshot
+}
+
+// scrubRule matches a piece of text that looks like a secret and describes
+// how to redact it.
+type scrubRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// builtinScrubRules cover the secret shapes that show up most often in dev
+// logs and build output.
+var builtinScrubRules = []scrubRule{
+	{name: "aws-access-key", re: regexp.MustCompile(`AKIA[0-9A-Z]{16}`)},
+	{name: "jwt", re: regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{name: "k8s-bearer-token", re: regexp.MustCompile(`\bBearer [A-Za-z0-9._-]{20,}`)},
+	{name: "password-assignment", re: regexp.MustCompile(`(?i)(password|passwd|secret)\s*[:=]\s*\S+`)},
+	{name: "private-key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+}
+
+// regexScrubber redacts anything matching a fixed list of scrubRules.
+type regexScrubber struct {
+	rules []scrubRule
+}
+
+func NewRegexScrubber(rules []scrubRule) SnapshotScrubber {
+	return regexScrubber{rules: rules}
+}
+
+// NewBuiltinScrubber returns a scrubber covering the common secret shapes
+// (AWS keys, JWTs, k8s bearer tokens, password= assignments, PEM private
+// keys), optionally combined with a user-supplied literal-string list.
+func NewBuiltinScrubber(literals []string) SnapshotScrubber {
+	rules := append([]scrubRule{}, builtinScrubRules...)
+	for _, lit := range literals {
+		if lit == "" {
+			continue
+		}
+		rules = append(rules, scrubRule{
+			name: "user-literal",
+			re:   regexp.MustCompile(regexp.QuoteMeta(lit)),
+		})
+	}
+	return regexScrubber{rules: rules}
+}
+
+func (s regexScrubber) Scrub(snapshot *proto_webview.Snapshot) *proto_webview.Snapshot {
+	if snapshot == nil || snapshot.View == nil || snapshot.View.LogList == nil {
+		return snapshot
+	}
+
+	for _, seg := range snapshot.View.LogList.Segments {
+		seg.Text = s.redactLine(seg.Text)
+	}
+
+	return snapshot
+}
+
+func (s regexScrubber) redactLine(line string) string {
+	for _, rule := range s.rules {
+		line = rule.re.ReplaceAllString(line, "[REDACTED:"+rule.name+"]")
+	}
+	return line
+}
+
+// ScrubConfig is the shape of a user-supplied tilt.scrub.yaml: literal
+// strings and JSONPath-style paths to strip before a snapshot is uploaded.
+type ScrubConfig struct {
+	Literals []string `yaml:"literals"`
+	Paths    []string `yaml:"paths"`
+}
+
+func LoadScrubConfig(path string) (ScrubConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ScrubConfig{}, errors.Wrap(err, "LoadScrubConfig")
+	}
+
+	var cfg ScrubConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return ScrubConfig{}, errors.Wrap(err, "LoadScrubConfig")
+	}
+	return cfg, nil
+}
+
+// NewScrubberFromConfig builds a scrubber from a user-supplied
+// tilt.scrub.yaml: the builtin rules, plus cfg.Literals verbatim, plus one
+// rule per cfg.Paths entry. A path like `resource.env.SECRET_KEY` redacts
+// any `SECRET_KEY: …`/`SECRET_KEY=…`-shaped assignment in log text — we
+// don't have a structured view of env vars in proto_webview.View to walk a
+// real JSONPath against, but build/pod logs are where secrets named by these
+// paths actually show up, so matching on the field's name there is what
+// users configuring this file actually want scrubbed.
+func NewScrubberFromConfig(cfg ScrubConfig) SnapshotScrubber {
+	rules := append([]scrubRule{}, builtinScrubRules...)
+	for _, lit := range cfg.Literals {
+		if lit == "" {
+			continue
+		}
+		rules = append(rules, scrubRule{
+			name: "user-literal",
+			re:   regexp.MustCompile(regexp.QuoteMeta(lit)),
+		})
+	}
+	for _, path := range cfg.Paths {
+		if path == "" {
+			continue
+		}
+		rules = append(rules, scrubRule{
+			name: "user-path:" + path,
+			re:   regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(lastPathSegment(path)) + `\s*[:=]\s*\S+`),
+		})
+	}
+	return regexScrubber{rules: rules}
+}
+
+func lastPathSegment(path string) string {
+	if idx := strings.LastIndex(path, "."); idx >= 0 {
+		return path[idx+1:]
+	}
+	return path
+}
+
+// defaultScrubber returns a scrubber built from tilt.scrub.yaml in the
+// current directory if one exists, falling back to the builtin rules alone.
+func defaultScrubber() SnapshotScrubber {
+	if _, err := os.Stat(tiltScrubConfigPath); err != nil {
+		return NewBuiltinScrubber(nil)
+	}
+	cfg, err := LoadScrubConfig(tiltScrubConfigPath)
+	if err != nil {
+		return NewBuiltinScrubber(nil)
+	}
+	return NewScrubberFromConfig(cfg)
+}
+
+// tiltScrubConfigPath is where defaultScrubber looks for a user-supplied
+// scrub config, relative to the directory `tilt` was invoked from.
+const tiltScrubConfigPath = "tilt.scrub.yaml"
@@ -0,0 +1,118 @@
+package cloud
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// tiltSnapshotSigningKeyIDEnvVar/tiltSnapshotSigningKeyPathEnvVar let a
+	// team opt every outgoing snapshot into signing without code changes:
+	// set both and NewSnapshotUploader picks them up via defaultSigner.
+	tiltSnapshotSigningKeyIDEnvVar   = "TILT_SNAPSHOT_SIGNING_KEY_ID"
+	tiltSnapshotSigningKeyPathEnvVar = "TILT_SNAPSHOT_SIGNING_KEY_PATH"
+)
+
+const (
+	// TiltSnapshotSignatureHeaderName carries a base64 ed25519 signature over
+	// the canonicalized JSON snapshot body.
+	TiltSnapshotSignatureHeaderName = "Tilt-Snapshot-Signature"
+	// TiltSnapshotKeyIDHeaderName identifies which key signed it, so a
+	// verifier knows which public key to check against.
+	TiltSnapshotKeyIDHeaderName = "Tilt-Snapshot-Key-Id"
+)
+
+// SnapshotSigner signs the canonicalized JSON bytes of an outgoing snapshot,
+// so a teammate who receives a snapshot link (e.g. posted in an incident
+// channel) can trust it actually came from the Tilt instance it claims to,
+// and wasn't tampered with in transit or server-side.
+type SnapshotSigner struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// NewSnapshotSigner loads a signing key from a local file, in the same
+// on-disk style as token.Token (a single file containing the key material).
+func NewSnapshotSigner(keyID string, keyPath string) (*SnapshotSigner, error) {
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewSnapshotSigner")
+	}
+
+	key, err := decodeEd25519PrivateKey(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewSnapshotSigner")
+	}
+
+	return &SnapshotSigner{keyID: keyID, key: key}, nil
+}
+
+// Sign returns the key-id and base64-encoded signature to send alongside a
+// snapshot upload.
+func (s *SnapshotSigner) Sign(canonicalJSON []byte) (keyID string, signature string) {
+	sig := ed25519.Sign(s.key, canonicalJSON)
+	return s.keyID, base64.StdEncoding.EncodeToString(sig)
+}
+
+// SnapshotVerifier checks a signature produced by SnapshotSigner.Sign
+// against a known public key, on the download side.
+type SnapshotVerifier struct {
+	publicKeys map[string]ed25519.PublicKey
+}
+
+func NewSnapshotVerifier(publicKeys map[string]ed25519.PublicKey) *SnapshotVerifier {
+	return &SnapshotVerifier{publicKeys: publicKeys}
+}
+
+// Verify reports whether signature (base64-encoded) is a valid ed25519
+// signature over canonicalJSON from the key identified by keyID.
+func (v *SnapshotVerifier) Verify(keyID string, canonicalJSON []byte, signature string) (bool, error) {
+	pub, ok := v.publicKeys[keyID]
+	if !ok {
+		return false, errors.Errorf("Verify: unknown key id %q", keyID)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, errors.Wrap(err, "Verify: decoding signature")
+	}
+
+	return ed25519.Verify(pub, canonicalJSON, sig), nil
+}
+
+// defaultSigner builds a SnapshotSigner from TILT_SNAPSHOT_SIGNING_KEY_ID/
+// TILT_SNAPSHOT_SIGNING_KEY_PATH if both are set, so a team that wants every
+// snapshot signed can opt in without code changes. Returns nil (no signing)
+// if either is unset or the key can't be loaded.
+func defaultSigner() *SnapshotSigner {
+	keyID := os.Getenv(tiltSnapshotSigningKeyIDEnvVar)
+	keyPath := os.Getenv(tiltSnapshotSigningKeyPathEnvVar)
+	if keyID == "" || keyPath == "" {
+		return nil
+	}
+
+	signer, err := NewSnapshotSigner(keyID, keyPath)
+	if err != nil {
+		return nil
+	}
+	return signer
+}
+
+func decodeEd25519PrivateKey(b []byte) (ed25519.PrivateKey, error) {
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+	n, err := base64.StdEncoding.Decode(decoded, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decodeEd25519PrivateKey")
+	}
+	decoded = decoded[:n]
+
+	if len(decoded) != ed25519.PrivateKeySize {
+		return nil, errors.Errorf("decodeEd25519PrivateKey: expected %d bytes, got %d", ed25519.PrivateKeySize, len(decoded))
+	}
+
+	return ed25519.PrivateKey(decoded), nil
+}
@@ -0,0 +1,116 @@
+package cloud
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestSigningKey(t *testing.T, dir string) (path string, pub ed25519.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+
+	path = filepath.Join(dir, "signing-key")
+	encoded := base64.StdEncoding.EncodeToString(priv)
+	if err := ioutil.WriteFile(path, []byte(encoded), 0600); err != nil {
+		t.Fatalf("writing test key: %v", err)
+	}
+	return path, pub
+}
+
+func TestSnapshotSignerVerifyRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilt-snapshot-signer-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath, pub := writeTestSigningKey(t, dir)
+
+	signer, err := NewSnapshotSigner("key-1", keyPath)
+	if err != nil {
+		t.Fatalf("NewSnapshotSigner: %v", err)
+	}
+
+	payload := []byte(`{"view":{}}`)
+	keyID, sig := signer.Sign(payload)
+	if keyID != "key-1" {
+		t.Fatalf("Sign: expected keyID %q, got %q", "key-1", keyID)
+	}
+
+	verifier := NewSnapshotVerifier(map[string]ed25519.PublicKey{"key-1": pub})
+	ok, err := verifier.Verify(keyID, payload, sig)
+	if err != nil {
+		t.Fatalf("Verify: unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("Verify: expected signature to verify, it didn't")
+	}
+}
+
+func TestSnapshotVerifierRejectsTamperedPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilt-snapshot-signer-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath, pub := writeTestSigningKey(t, dir)
+
+	signer, err := NewSnapshotSigner("key-1", keyPath)
+	if err != nil {
+		t.Fatalf("NewSnapshotSigner: %v", err)
+	}
+
+	keyID, sig := signer.Sign([]byte(`{"view":{}}`))
+
+	verifier := NewSnapshotVerifier(map[string]ed25519.PublicKey{"key-1": pub})
+	ok, err := verifier.Verify(keyID, []byte(`{"view":{"tampered":true}}`), sig)
+	if err != nil {
+		t.Fatalf("Verify: unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("Verify: expected a tampered payload to fail verification")
+	}
+}
+
+func TestSnapshotVerifierUnknownKeyID(t *testing.T) {
+	verifier := NewSnapshotVerifier(map[string]ed25519.PublicKey{})
+	_, err := verifier.Verify("nonexistent", []byte("data"), "deadbeef")
+	if err == nil {
+		t.Fatal("Verify: expected an error for an unknown key id")
+	}
+}
+
+func TestDefaultSignerRequiresBothEnvVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilt-snapshot-signer-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath, _ := writeTestSigningKey(t, dir)
+
+	t.Run("neither set", func(t *testing.T) {
+		os.Unsetenv(tiltSnapshotSigningKeyIDEnvVar)
+		os.Unsetenv(tiltSnapshotSigningKeyPathEnvVar)
+		if s := defaultSigner(); s != nil {
+			t.Fatal("defaultSigner: expected nil with no env vars set")
+		}
+	})
+
+	t.Run("both set", func(t *testing.T) {
+		os.Setenv(tiltSnapshotSigningKeyIDEnvVar, "key-1")
+		os.Setenv(tiltSnapshotSigningKeyPathEnvVar, keyPath)
+		defer os.Unsetenv(tiltSnapshotSigningKeyIDEnvVar)
+		defer os.Unsetenv(tiltSnapshotSigningKeyPathEnvVar)
+
+		if s := defaultSigner(); s == nil {
+			t.Fatal("defaultSigner: expected a signer with both env vars set")
+		}
+	})
+}
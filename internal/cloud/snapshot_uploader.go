@@ -2,8 +2,10 @@ package cloud
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 
@@ -21,20 +23,69 @@ type SnapshotID string
 
 type SnapshotUploader interface {
 	TakeAndUpload(state store.EngineState) (SnapshotID, error)
-	Upload(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotID, error)
+	Upload(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotUploadResult, error)
 	IDToSnapshotURL(id SnapshotID) string
 }
 
 type snapshotUploader struct {
-	client HttpClient
-	addr   cloudurl.Address
+	client   HttpClient
+	addr     cloudurl.Address
+	scrubber SnapshotScrubber
+	signer   *SnapshotSigner
+	chunked  *ChunkedSnapshotUploader
+	codec    SnapshotCodec
+	gzip     bool
 }
 
 func NewSnapshotUploader(client HttpClient, addr cloudurl.Address) SnapshotUploader {
-	return snapshotUploader{
-		client: client,
-		addr:   addr,
+	s := snapshotUploader{
+		client:   client,
+		addr:     addr,
+		scrubber: defaultScrubber(),
+		chunked:  NewChunkedSnapshotUploader(client, addr),
 	}
+	if signer := defaultSigner(); signer != nil {
+		s = s.WithSigner(signer)
+	}
+	codec, gzipOK := defaultTransportOverride()
+	if codec != nil {
+		s = s.WithCodec(codec)
+	}
+	if gzipOK {
+		s = s.WithGzip()
+	}
+	return s
+}
+
+// WithSigner returns a copy of the uploader that signs every snapshot it
+// sends with signer, so a teammate on the receiving end can verify it really
+// came from this Tilt instance. Signing is opt-in (most users have no
+// signing key configured).
+func (s snapshotUploader) WithSigner(signer *SnapshotSigner) snapshotUploader {
+	s.signer = signer
+	return s
+}
+
+// WithCodec returns a copy of the uploader that always encodes snapshots
+// with codec instead of the default (JSON). Protobuf support has to be
+// confirmed out of band (it's not something this client can safely
+// autodetect — see defaultSnapshotTransport), so a caller who knows their
+// endpoint understands it opts in explicitly here. NewSnapshotUploader calls
+// this for you when TILT_SNAPSHOT_CODEC is set (see defaultTransportOverride).
+func (s snapshotUploader) WithCodec(codec SnapshotCodec) snapshotUploader {
+	s.codec = codec
+	return s
+}
+
+// WithGzip returns a copy of the uploader that gzip-compresses the request
+// body. Like WithCodec, this is opt-in rather than auto-detected (set via
+// TILT_SNAPSHOT_GZIP, see defaultTransportOverride): unlike a
+// response, a request body's compression isn't something the server can
+// advertise support for ahead of time, so a caller who knows their endpoint
+// decodes Content-Encoding: gzip turns it on explicitly.
+func (s snapshotUploader) WithGzip() snapshotUploader {
+	s.gzip = true
+	return s
 }
 
 func (s snapshotUploader) newSnapshotURL() string {
@@ -72,7 +123,15 @@ func (s snapshotUploader) TakeAndUpload(state store.EngineState) (SnapshotID, er
 	if err != nil {
 		return "", err
 	}
-	return s.Upload(state.Token, state.TeamName, &proto_webview.Snapshot{View: view})
+	snapshot := &proto_webview.Snapshot{View: view}
+	if s.scrubber != nil {
+		snapshot = s.scrubber.Scrub(snapshot)
+	}
+	result, err := s.Upload(state.Token, state.TeamName, snapshot)
+	if err != nil {
+		return "", err
+	}
+	return result.ID, nil
 }
 
 func cleanSnapshot(snapshot *proto_webview.Snapshot) *proto_webview.Snapshot {
@@ -80,25 +139,138 @@ func cleanSnapshot(snapshot *proto_webview.Snapshot) *proto_webview.Snapshot {
 	return snapshot
 }
 
-func (s snapshotUploader) Upload(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotID, error) {
+func (s snapshotUploader) Upload(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotUploadResult, error) {
 	snapshot = cleanSnapshot(snapshot)
 
-	b := &bytes.Buffer{}
-	jsEncoder := &runtime.JSONPb{OrigName: false, EmitDefaults: true}
-	err := jsEncoder.NewEncoder(b).Encode(snapshot)
+	codec, gzipOK := defaultSnapshotTransport()
+	if s.codec != nil {
+		codec = s.codec
+	}
+	if s.gzip {
+		gzipOK = true
+	}
+
+	// Signing always needs the canonicalized JSON bytes, and a non-JSON
+	// codec or gzip compression both need the full encoded body in memory,
+	// so only the plain-JSON/no-signer/no-gzip path keeps the io.Pipe
+	// streaming benefit.
+	if s.signer == nil && codec.Name() == "json" && !gzipOK {
+		return s.uploadStreaming(token, teamID, snapshot)
+	}
+
+	var canonicalJSON []byte
+	encoded, err := codec.Encode(snapshot)
 	if err != nil {
-		return "", errors.Wrap(err, "encoding snapshot")
+		return SnapshotUploadResult{}, errors.Wrap(err, "encoding snapshot")
 	}
-	request, err := http.NewRequest(http.MethodPost, s.newSnapshotURL(), b)
+
+	// An oversized snapshot that has to be buffered whole anyway is split
+	// into chunks by ChunkedSnapshotUploader, so one dropped connection only
+	// costs a retry of the chunks that actually failed. Chunked upload sends
+	// unsigned, uncompressed JSON, so it only kicks in for an unsigned JSON
+	// snapshot; since the unsigned, uncompressed JSON case streams above,
+	// that means only when gzip is on. Signed uploads are never chunked.
+	if s.chunked != nil && s.signer == nil && codec.Name() == jsonSnapshotCodec{}.Name() && len(encoded) > chunkedUploadThresholdBytes {
+		return s.uploadChunked(token, teamID, snapshot)
+	}
+
+	if codec.Name() == "json" {
+		canonicalJSON = encoded
+	} else if s.signer != nil {
+		canonicalJSON, err = encodeSnapshotJSON(snapshot)
+		if err != nil {
+			return SnapshotUploadResult{}, errors.Wrap(err, "encoding snapshot")
+		}
+	}
+
+	uncompressedSize := len(encoded)
+	body := encoded
+	gzipped := false
+	if gzipOK {
+		body, err = gzipBytes(encoded)
+		if err != nil {
+			return SnapshotUploadResult{}, err
+		}
+		gzipped = true
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.newSnapshotURL(), bytes.NewReader(body))
+	if err != nil {
+		return SnapshotUploadResult{}, errors.Wrap(err, "Upload NewRequest")
+	}
+	request.Header.Set("Content-Type", codec.ContentType())
+	if gzipped {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	s.setCommonHeaders(request, token, teamID)
+	if s.signer != nil {
+		keyID, signature := s.signer.Sign(canonicalJSON)
+		request.Header.Set(TiltSnapshotKeyIDHeaderName, keyID)
+		request.Header.Set(TiltSnapshotSignatureHeaderName, signature)
+	}
+
+	id, err := s.do(request)
+	if err != nil {
+		return SnapshotUploadResult{}, err
+	}
+
+	return SnapshotUploadResult{
+		ID:               id,
+		Codec:            codec.Name(),
+		Gzipped:          gzipped,
+		UncompressedSize: uncompressedSize,
+		CompressedSize:   len(body),
+	}, nil
+}
+
+// uploadChunked hands an already-encoded-as-oversized snapshot off to
+// ChunkedSnapshotUploader instead of sending it as one POST body.
+func (s snapshotUploader) uploadChunked(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotUploadResult, error) {
+	id, err := s.chunked.Upload(context.Background(), token, teamID, snapshot)
+	if err != nil {
+		return SnapshotUploadResult{}, err
+	}
+	return SnapshotUploadResult{ID: id, Codec: jsonSnapshotCodec{}.Name()}, nil
+}
+
+// uploadStreaming is the original path: stream the JSON encoding directly
+// into the request body via an io.Pipe so a large snapshot never has to be
+// buffered whole in memory.
+func (s snapshotUploader) uploadStreaming(token token.Token, teamID string, snapshot *proto_webview.Snapshot) (SnapshotUploadResult, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		jsEncoder := &runtime.JSONPb{OrigName: false, EmitDefaults: true}
+		err := jsEncoder.NewEncoder(pw).Encode(snapshot)
+		if err != nil {
+			_ = pw.CloseWithError(errors.Wrap(err, "encoding snapshot"))
+			return
+		}
+		_ = pw.Close()
+	}()
+
+	request, err := http.NewRequest(http.MethodPost, s.newSnapshotURL(), pr)
 	if err != nil {
-		return "", errors.Wrap(err, "Upload NewRequest")
+		return SnapshotUploadResult{}, errors.Wrap(err, "Upload NewRequest")
 	}
+	request.Header.Set("Content-Type", jsonSnapshotCodec{}.ContentType())
+	s.setCommonHeaders(request, token, teamID)
 
+	id, err := s.do(request)
+	if err != nil {
+		return SnapshotUploadResult{}, err
+	}
+
+	return SnapshotUploadResult{ID: id, Codec: jsonSnapshotCodec{}.Name()}, nil
+}
+
+func (s snapshotUploader) setCommonHeaders(request *http.Request, token token.Token, teamID string) {
 	request.Header.Set(TiltTokenHeaderName, token.String())
 	if teamID != "" {
 		request.Header.Set(TiltTeamIDNameHeaderName, teamID)
 	}
+}
 
+func (s snapshotUploader) do(request *http.Request) (SnapshotID, error) {
 	response, err := s.client.Do(request)
 	if err != nil {
 		return "", errors.Wrap(err, "Upload")
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/tilt-dev/tilt/internal/build"
+	"github.com/tilt-dev/tilt/internal/docker"
+	"github.com/tilt-dev/tilt/internal/hud"
+	"github.com/tilt-dev/tilt/pkg/logger"
+	"github.com/tilt-dev/tilt/pkg/model"
+	"github.com/tilt-dev/tilt/pkg/model/logstore"
+)
+
+// DockerLogStreamer gives `tilt logs --follow` something useful to do when
+// no HUD server is reachable at localhost:10350 (e.g. the engine isn't
+// running, or we're pointed at a plain docker-compose-style stack). Instead
+// of reading a webview over a websocket, it discovers containers directly
+// via the BuildMode label applied by applyLabels and tails them with the
+// Docker API. WebsocketReader.Listen falls back to it automatically when
+// constructed via ProvideWebsockerReaderWithDockerFallback.
+type DockerLogStreamer struct {
+	dCli     docker.Client
+	logstore *logstore.LogStore
+	printer  *hud.IncrementalPrinter
+	since    time.Time
+}
+
+func NewDockerLogStreamer(dCli docker.Client, printer *hud.IncrementalPrinter) *DockerLogStreamer {
+	return &DockerLogStreamer{
+		dCli:     dCli,
+		logstore: logstore.NewLogStore(),
+		printer:  printer,
+		since:    time.Now(),
+	}
+}
+
+// Listen discovers containers tagged with the Tilt BuildMode label and tails
+// their logs until ctx is canceled, picking up newly-started containers as
+// they appear on the Docker event stream.
+func (s *DockerLogStreamer) Listen(ctx context.Context) error {
+	containers, err := s.dCli.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", build.BuildMode)),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		s.tailContainer(ctx, c.ID)
+	}
+
+	events, errs := s.dCli.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", "container"), filters.Arg("label", build.BuildMode)),
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if err == io.EOF || err == nil {
+				return nil
+			}
+			return err
+		case event := <-events:
+			if event.Action == "start" {
+				s.tailContainer(ctx, event.Actor.ID)
+			}
+		}
+	}
+}
+
+// tailContainer starts a goroutine following one container's combined
+// stdout/stderr and appending it to the shared logstore, where it gets
+// printed the same way LogStreamer.Handle prints websocket-delivered logs.
+func (s *DockerLogStreamer) tailContainer(ctx context.Context, containerID string) {
+	go func() {
+		reader, err := s.dCli.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Since:      s.since.Format(time.RFC3339),
+		})
+		if err != nil {
+			logger.Get(ctx).Debugf("tailing container %s: %v", containerID, err)
+			return
+		}
+		defer func() { _ = reader.Close() }()
+
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(pw, pw, reader)
+			_ = pw.CloseWithError(err)
+		}()
+
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			checkpoint := s.logstore.Checkpoint()
+			s.logstore.Append(containerLogEvent(containerID, scanner.Text()), model.SecretSet{})
+			s.printer.Print(s.logstore.ContinuingLines(checkpoint))
+		}
+	}()
+}
+
+// containerLogEvent wraps one line of raw container output as a logstore
+// event, labeled with the short container ID the way `docker logs` would.
+func containerLogEvent(containerID, text string) logstore.LogEvent {
+	return logstore.NewLogEvent(model.TargetName(shortID(containerID)), logstore.SpanID(containerID), time.Now(), []byte(text+"\n"))
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
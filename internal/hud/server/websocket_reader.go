@@ -26,6 +26,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/mattn/go-colorable"
 
+	"github.com/tilt-dev/tilt/internal/docker"
 	"github.com/tilt-dev/tilt/internal/hud"
 	"github.com/tilt-dev/tilt/internal/hud/webview"
 	"github.com/tilt-dev/tilt/pkg/model"
@@ -37,6 +38,12 @@ import (
 type WebsocketReader struct {
 	url     url.URL
 	handler ViewHandler
+
+	// fallback, if set, is tried by Listen when the websocket can't be
+	// dialed at all, so `tilt logs --follow` still has something useful to
+	// do against a stack with no Tilt server running (e.g. a plain
+	// docker-compose-style setup).
+	fallback *DockerLogStreamer
 }
 
 func ProvideWebsockerReader() *WebsocketReader {
@@ -47,6 +54,15 @@ func ProvideWebsockerReader() *WebsocketReader {
 	}
 }
 
+// ProvideWebsockerReaderWithDockerFallback is ProvideWebsockerReader, but
+// with dCli wired in as a direct-container-tailing fallback for when no Tilt
+// server answers at localhost:10350.
+func ProvideWebsockerReaderWithDockerFallback(dCli docker.Client) *WebsocketReader {
+	wsr := ProvideWebsockerReader()
+	wsr.fallback = NewDockerLogStreamer(dCli, wsr.handler.(*LogStreamer).printer)
+	return wsr
+}
+
 type ViewHandler interface {
 	Handle(v proto_webview.View) error
 }
@@ -102,7 +118,11 @@ func (wsr *WebsocketReader) Listen(ctx context.Context) error {
 
 	c, _, err := websocket.DefaultDialer.Dial(wsr.url.String(), nil)
 	if err != nil {
-		return errors.Wrapf(err, "dialing websocket %s", wsr.url.String())
+		if wsr.fallback == nil {
+			return errors.Wrapf(err, "dialing websocket %s", wsr.url.String())
+		}
+		logger.Get(ctx).Debugf("no Tilt server at %s (%v), falling back to direct container log tailing", wsr.url.String(), err)
+		return wsr.fallback.Listen(ctx)
 	}
 	defer c.Close()
 